@@ -0,0 +1,10 @@
+package core
+
+// ctxkey is an unexported type for context keys defined in this package so
+// they can't collide with keys set by other packages.
+type ctxkey int
+
+// AuthNameKey is the context key under which the name of the Auth config
+// (see serv/auth.NewAuthChain) that authenticated the request is stored,
+// so role rules can gate on $auth_name.
+const AuthNameKey ctxkey = 0
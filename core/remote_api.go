@@ -1,24 +1,42 @@
 package core
 
 import (
+	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httputil"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/dosco/graphjin/v2/internal/jsn"
+	"github.com/dosco/graphjin/v2/serv/auth/provider"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // RemoteAPI struct defines a remote API endpoint
 type remoteAPI struct {
-	URL   string
-	Debug bool
+	URL    string
+	Method string
+	Debug  bool
 
 	PassHeaders []string
 	SetHeaders  []remoteHdrs
+
+	BodyTemplate *template.Template
+
+	Timeout time.Duration
+	Retry   remoteRetry
+
+	ForwardAuth remoteForwardAuth
 }
 
 type remoteHdrs struct {
@@ -26,6 +44,27 @@ type remoteHdrs struct {
 	Value string
 }
 
+type remoteRetry struct {
+	Max      int
+	Backoff  time.Duration
+	OnStatus map[int]bool
+}
+
+type remoteForwardAuth struct {
+	// Mode is one of "", "passthrough" or "service_jwt"
+	Mode string
+	JWT  provider.JWTConfig
+}
+
+// remoteAPITemplateData is what body_template is rendered against: the
+// current resolver request plus the caller's auth context, so templates
+// can reference things like {{.UserID}}.
+type remoteAPITemplateData struct {
+	ResolverReq
+	UserID string
+	Role   string
+}
+
 func newRemoteAPI(v map[string]interface{}) (*remoteAPI, error) {
 	var ra remoteAPI
 
@@ -45,48 +84,141 @@ func newRemoteAPI(v map[string]interface{}) (*remoteAPI, error) {
 		}
 	}
 
+	ra.Method = http.MethodGet
+	if v, ok := v["method"].(string); ok && v != "" {
+		ra.Method = strings.ToUpper(v)
+	}
+
+	if v, ok := v["body_template"].(string); ok && v != "" {
+		t, err := template.New("body_template").Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("remote api '%s': body_template: %w", ra.URL, err)
+		}
+		ra.BodyTemplate = t
+	}
+
+	ra.Timeout = 30 * time.Second
+	if v, ok := v["timeout"].(string); ok && v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("remote api '%s': timeout: %w", ra.URL, err)
+		}
+		ra.Timeout = d
+	}
+
+	if v, ok := v["retry"].(map[string]interface{}); ok {
+		if n, ok := v["max"].(int); ok {
+			ra.Retry.Max = n
+		}
+		ra.Retry.Backoff = 100 * time.Millisecond
+		if s, ok := v["backoff"].(string); ok && s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return nil, fmt.Errorf("remote api '%s': retry.backoff: %w", ra.URL, err)
+			}
+			ra.Retry.Backoff = d
+		}
+		ra.Retry.OnStatus = map[int]bool{502: true, 503: true, 504: true}
+		if codes, ok := v["on_status"].([]int); ok && len(codes) != 0 {
+			ra.Retry.OnStatus = make(map[int]bool, len(codes))
+			for _, c := range codes {
+				ra.Retry.OnStatus[c] = true
+			}
+		}
+	}
+
+	if v, ok := v["forward_auth"].(map[string]interface{}); ok {
+		if m, ok := v["mode"].(string); ok {
+			ra.ForwardAuth.Mode = m
+		}
+		if jv, ok := v["jwt"].(map[string]interface{}); ok {
+			if s, ok := jv["secret"].(string); ok {
+				ra.ForwardAuth.JWT.Secret = s
+			}
+		}
+	}
+
 	return &ra, nil
 }
 
 func (r *remoteAPI) Resolve(c context.Context, rr ResolverReq) ([]byte, error) {
 	client := &http.Client{
 		Transport: otelhttp.NewTransport(http.DefaultTransport),
+		Timeout:   r.Timeout,
 	}
 
 	uri := strings.ReplaceAll(r.URL, "$id", rr.ID)
 
-	req, err := http.NewRequestWithContext(c, "GET", uri, nil)
+	var lastErr error
+	var b []byte
+
+	for attempt := 0; attempt <= r.Retry.Max; attempt++ {
+		if attempt > 0 {
+			time.Sleep(r.Retry.Backoff * time.Duration(attempt))
+		}
+
+		var status int
+		b, status, lastErr = r.do(c, client, uri, rr)
+
+		if span := trace.SpanFromContext(c); span != nil {
+			span.SetAttributes(
+				attribute.String("remote_api.method", r.Method),
+				attribute.Int("remote_api.status_code", status),
+				attribute.Int("remote_api.retry_count", attempt),
+			)
+		}
+
+		if lastErr == nil {
+			return b, nil
+		}
+		if !r.Retry.OnStatus[status] {
+			break
+		}
+	}
+
+	return nil, lastErr
+}
+
+func (r *remoteAPI) do(c context.Context, client *http.Client, uri string, rr ResolverReq) ([]byte, int, error) {
+	body, err := r.renderBody(c, rr)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	req, err := http.NewRequestWithContext(c, r.Method, uri, body)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	// if host, ok := hdr["Host"]; ok {
-	// 	req.Host = host[0]
-	// }
+	for _, h := range r.PassHeaders {
+		if v := rr.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
 
 	for _, v := range r.SetHeaders {
 		req.Header.Set(v.Name, v.Value)
 	}
 
-	// for _, v := range r.PassHeaders {
-	// 	req.Header.Set(v, hdr.Get(v))
-	// }
+	if err := r.applyForwardAuth(req, c, rr); err != nil {
+		return nil, 0, err
+	}
 
 	res, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to '%s': %v", uri, err)
+		return nil, 0, fmt.Errorf("failed to connect to '%s': %v", uri, err)
 	}
 	defer res.Body.Close()
 
 	if r.Debug {
 		reqDump, err := httputil.DumpRequestOut(req, true)
 		if err != nil {
-			return nil, err
+			return nil, res.StatusCode, err
 		}
 
 		resDump, err := httputil.DumpResponse(res, true)
 		if err != nil {
-			return nil, err
+			return nil, res.StatusCode, err
 		}
 
 		rr.Log.Printf("DBG Remote Request:\n%s\n%s",
@@ -94,18 +226,98 @@ func (r *remoteAPI) Resolve(c context.Context, rr ResolverReq) ([]byte, error) {
 	}
 
 	if res.StatusCode != 200 {
-		return nil,
+		return nil, res.StatusCode,
 			fmt.Errorf("server responded with a %d", res.StatusCode)
 	}
 
 	b, err := io.ReadAll(res.Body)
 	if err != nil {
-		return nil, err
+		return nil, res.StatusCode, err
 	}
 
 	if err := jsn.ValidateBytes(b); err != nil {
-		return nil, err
+		return nil, res.StatusCode, err
 	}
 
-	return b, nil
+	return b, res.StatusCode, nil
+}
+
+// renderBody renders BodyTemplate, if configured, against the resolver
+// request and the caller's auth context. GET/HEAD requests never carry a
+// body regardless of BodyTemplate.
+func (r *remoteAPI) renderBody(c context.Context, rr ResolverReq) (io.Reader, error) {
+	if r.BodyTemplate == nil || r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return nil, nil
+	}
+
+	data := remoteAPITemplateData{ResolverReq: rr}
+	if v := c.Value(UserIDKey); v != nil {
+		data.UserID = fmt.Sprintf("%v", v)
+	}
+	if v, ok := c.Value(UserRoleKey).(string); ok {
+		data.Role = v
+	}
+
+	var buf bytes.Buffer
+	if err := r.BodyTemplate.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("remote api '%s': body_template: %w", r.URL, err)
+	}
+	return &buf, nil
+}
+
+// applyForwardAuth implements the "forward_auth" modes: "passthrough"
+// reissues the caller's own Authorization header, "service_jwt" mints a
+// short-lived HS256 JWT carrying the caller's user id, signed with the
+// secret set under forward_auth.jwt (the "jwt" auth type's config is not
+// read here), so a downstream service sharing that secret can enforce the
+// same identity.
+func (r *remoteAPI) applyForwardAuth(req *http.Request, c context.Context, rr ResolverReq) error {
+	switch r.ForwardAuth.Mode {
+	case "":
+		return nil
+
+	case "passthrough":
+		if v := rr.Header.Get("Authorization"); v != "" {
+			req.Header.Set("Authorization", v)
+		}
+		return nil
+
+	case "service_jwt":
+		tok, err := signServiceJWT(r.ForwardAuth.JWT, c.Value(UserIDKey))
+		if err != nil {
+			return fmt.Errorf("remote api '%s': forward_auth: %w", r.URL, err)
+		}
+		req.Header.Set("Authorization", "Bearer "+tok)
+		return nil
+
+	default:
+		return fmt.Errorf("remote api '%s': unknown forward_auth mode: %s", r.URL, r.ForwardAuth.Mode)
+	}
+}
+
+// signServiceJWT mints a minimal HS256 service-to-service JWT carrying
+// the caller's user id, signed with jc.Secret.
+func signServiceJWT(jc provider.JWTConfig, userID interface{}) (string, error) {
+	header := map[string]string{"alg": "HS256", "typ": "JWT"}
+	claims := map[string]interface{}{
+		"sub": fmt.Sprintf("%v", userID),
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}
+
+	hb, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	cb, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(hb) + "." + base64.RawURLEncoding.EncodeToString(cb)
+
+	mac := hmac.New(sha256.New, []byte(jc.Secret))
+	mac.Write([]byte(signingInput))
+	sig := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+	return signingInput + "." + sig, nil
 }
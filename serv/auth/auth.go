@@ -35,10 +35,12 @@ package auth
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	core "github.com/dosco/graphjin/v2/core"
 	"github.com/gorilla/websocket"
@@ -57,11 +59,26 @@ type Auth struct {
 	// Enable development mode used to set credentials in the header and vars for testing
 	Development bool `jsonschema:"title=Development Mode,default=false"`
 
-	// Name is a friendly name for this auth config
+	// Name is a friendly name for this auth config. Required when this
+	// Auth is part of a chain built with NewAuthChain so that role rules
+	// can gate on the $auth_name that ended up authenticating the request
 	Name string
 
-	// Type can be one of rails, jwt or header
-	Type string `jsonschema:"title=Type,enum=jwt,enum=rails,enum=header"`
+	// Type can be one of rails, jwt, header, oidc, social or magiclink
+	Type string `jsonschema:"title=Type,enum=jwt,enum=rails,enum=header,enum=oidc,enum=social,enum=magiclink"`
+
+	// MatchPath, when set, restricts this Auth (only meaningful inside a
+	// chain built with NewAuthChain) to requests whose URL path has this
+	// prefix
+	MatchPath string `mapstructure:"match_path"`
+
+	// MatchHeader, when set, restricts this Auth (only meaningful inside a
+	// chain built with NewAuthChain) to requests carrying a header with
+	// this name and, if Value is set, this value
+	MatchHeader struct {
+		Name  string
+		Value string
+	} `mapstructure:"match_header"`
 
 	// The name of the cookie that holds the authentication token
 	Cookie string `jsonschema:"title=Cookie Name"`
@@ -120,12 +137,146 @@ type Auth struct {
 		// Exists if set to true then the header must exist
 		// this is an alternative to using value
 		Exists bool
+
+		// Bearer, when set, switches this Header auth to validating
+		// "Authorization: <Scheme> <token>" opaque bearer tokens instead
+		// of comparing Name/Value, by calling an RFC 7662 introspection
+		// endpoint (or Options.HeaderBearerVerifier when supplied)
+		Bearer struct {
+			// Scheme is the authorization scheme prefix. Defaults to
+			// "Bearer" when unset
+			Scheme string
+
+			// Introspection configures the RFC 7662 token introspection
+			// endpoint used to validate the token
+			Introspection struct {
+				// URL of the introspection endpoint
+				URL string
+
+				// CacheTTL caches introspection results per token so every
+				// request doesn't need a round-trip (ex. "30s")
+				CacheTTL string `mapstructure:"cache_ttl"`
+			}
+
+			// ClaimUserID is the introspection response claim used to
+			// populate core.UserIDKey. Defaults to "sub" when unset
+			ClaimUserID string `mapstructure:"claim_user_id"`
+
+			// ClaimUserRole is the introspection response claim used to
+			// populate core.UserRoleKey
+			ClaimUserRole string `mapstructure:"claim_user_role"`
+		}
+	}
+
+	// Magic.link authentication: a single-use, emailed login link that
+	// mints a normal JWT cookie, so no new verification path is needed -
+	// the resulting cookie is read by the "jwt" type's JwtHandler
+	MagicLink struct {
+		// Secret is the HMAC key used to sign the single-use login token
+		Secret string
+
+		// CookieHTTPS sets the secure parameter of the JWT cookie minted
+		// after a successful verify
+		CookieHTTPS bool `mapstructure:"cookie_https"`
+
+		// CookieExpiry sets the expiry parameter of the JWT cookie minted
+		// after a successful verify (ex. "20m", "2h")
+		CookieExpiry string `mapstructure:"cookie_expiry"`
+
+		// FromEmail is the "From" address used on the login link email
+		FromEmail string `mapstructure:"from_email"`
+
+		// SMTPConfig configures the mail server used to send login links
+		SMTPConfig struct {
+			Host     string
+			Port     int
+			User     string
+			Password string
+		} `mapstructure:"smtp"`
+
+		// TokenTTL is how long a requested login link stays valid
+		// (ex. "15m"). Defaults to 15m when unset
+		TokenTTL string `mapstructure:"token_ttl"`
 	}
 
-	// Magic.link authentication
-	// MagicLink struct {
-	// 	Secret string
-	// }
+	// OpenID Connect authentication (authorization-code flow)
+	OIDC struct {
+		// Issuer is the OIDC provider's issuer URL, used to fetch
+		// "/.well-known/openid-configuration" for discovery
+		Issuer string `jsonschema:"title=Issuer URL"`
+
+		// ClientID is the OAuth2 client id registered with the issuer
+		ClientID string `mapstructure:"client_id"`
+
+		// ClientSecret is the OAuth2 client secret registered with the issuer
+		ClientSecret string `mapstructure:"client_secret"`
+
+		// Scopes requested during the authorization-code flow. Defaults to
+		// "openid", "profile" and "email" when unset
+		Scopes []string
+
+		// RedirectURL is the callback URL registered with the issuer. It is
+		// sent as-is as redirect_uri; no default is derived from the request
+		// when it is unset
+		RedirectURL string `mapstructure:"redirect_url"`
+
+		// CookieSecret is a 32-byte key (hex or base64 encoded) used to
+		// encrypt the session cookie that carries the tokens
+		CookieSecret string `mapstructure:"cookie_secret"`
+
+		// CookieHTTPS sets the secure parameter of the session cookie
+		CookieHTTPS bool `mapstructure:"cookie_https"`
+
+		// CookieExpiry sets the expiry parameter of the session cookie
+		// (ex. "20m", "2h")
+		CookieExpiry string `mapstructure:"cookie_expiry"`
+
+		// RefreshSkew is how far ahead of the access token's expiry a
+		// refresh is attempted (ex. "30s"). Defaults to 30s when unset
+		RefreshSkew string `mapstructure:"refresh_skew"`
+
+		// UserIDClaim is the ID token claim used to populate core.UserIDKey.
+		// Defaults to "sub" when unset
+		UserIDClaim string `mapstructure:"user_id_claim"`
+
+		// UserRoleClaim is the ID token claim used to populate core.UserRoleKey
+		UserRoleClaim string `mapstructure:"user_role_claim"`
+	}
+
+	// Social sign-in through one or more external OAuth2 providers
+	// (github, gitlab, google, ...) linked to local user accounts
+	Social struct {
+		// Providers configured for sign-in, keyed by provider name
+		Providers map[string]SocialProvider
+
+		// CookieSecret is a 32-byte key (hex or base64 encoded) used to
+		// encrypt the session cookie issued after a successful link
+		CookieSecret string `mapstructure:"cookie_secret"`
+
+		// CookieHTTPS sets the secure parameter of the session cookie
+		CookieHTTPS bool `mapstructure:"cookie_https"`
+
+		// CookieExpiry sets the expiry parameter of the session cookie
+		// (ex. "20m", "2h")
+		CookieExpiry string `mapstructure:"cookie_expiry"`
+	}
+}
+
+// SocialProvider is the per-provider OAuth2 config used for social sign-in
+type SocialProvider struct {
+	// ClientID is the OAuth2 client id registered with the provider
+	ClientID string `mapstructure:"client_id"`
+
+	// ClientSecret is the OAuth2 client secret registered with the provider
+	ClientSecret string `mapstructure:"client_secret"`
+
+	// Scopes requested during sign-in
+	Scopes []string
+
+	// RedirectURL is the callback URL registered with the provider. No
+	// default is derived from the request: when unset, no redirect_uri is
+	// sent and the provider uses the one registered for the client
+	RedirectURL string `mapstructure:"redirect_url"`
 }
 
 type HandlerFunc func(w http.ResponseWriter, r *http.Request) (context.Context, error)
@@ -133,12 +284,40 @@ type HandlerFunc func(w http.ResponseWriter, r *http.Request) (context.Context,
 type Options struct {
 	// Return a HTTP '401 Unauthoized' when auth fails
 	AuthFailBlock bool
+
+	// DB is the primary GraphJin database connection. It's required by
+	// auth types that persist their own state, such as "social" for its
+	// external_login_user linking table
+	DB *sql.DB
+
+	// OnUnlinkedLogin is called by the "social" auth type when a provider
+	// callback succeeds but the external identity isn't linked to a local
+	// user yet. Return the user id to treat the login as successful (e.g.
+	// after auto-creating an account), or an error to reject it.
+	OnUnlinkedLogin SocialUnlinkedLoginFunc
+
+	// MagicLinkNonceStore backs the "magiclink" auth type's replay
+	// protection. Defaults to an in-memory store; set this to a
+	// Redis-backed implementation when running more than one instance.
+	MagicLinkNonceStore MagicLinkNonceStore
+
+	// Mailer sends the "magiclink" auth type's login link emails.
+	// Defaults to dialing Auth.MagicLink.SMTPConfig directly.
+	Mailer MagicLinkMailer
+
+	// HeaderBearerVerifier, when set, validates Header.Bearer tokens
+	// in-process instead of calling Header.Bearer.Introspection.URL. A
+	// nil error means active; any other error is treated as a transient
+	// failure and isn't cached, so return a definitive rejection by
+	// wrapping it with ErrBearerTokenNotActive if the verifier can tell the
+	// two apart.
+	HeaderBearerVerifier func(ctx context.Context, token string) (map[string]interface{}, error)
 }
 
 // NewAuthHandlerFunc returns a HandlerFunc based on the provided config.
 // Usually you don't need to use this function, because is called by NewAuth if
 // no HandlerFunc is provided.
-func NewAuthHandlerFunc(ac Auth) (HandlerFunc, error) {
+func NewAuthHandlerFunc(ac Auth, opt Options) (HandlerFunc, error) {
 	var h HandlerFunc
 	var err error
 
@@ -155,10 +334,17 @@ func NewAuthHandlerFunc(ac Auth) (HandlerFunc, error) {
 			h, err = JwtHandler(ac)
 
 		case "header":
-			h, err = HeaderHandler(ac)
+			h, err = HeaderHandler(ac, opt)
+
+		case "oidc":
+			h, err = OIDCHandler(ac)
+
+		case "social":
+			h, err = SocialHandler(ac, opt)
+
+		case "magiclink":
+			h, err = MagicLinkHandler(ac)
 
-		// case "magiclink":
-		// 	h, err = MagicLinkHandler(ac, next)
 		case "", "none":
 			return nil, ErrNoAuthDefined
 
@@ -188,14 +374,24 @@ func NewAuth(ac Auth, log *zap.Logger, opt Options, hFn ...HandlerFunc) (
 		h = hFn[0]
 		wsAuthSupported = true
 	} else {
-		h, err = NewAuthHandlerFunc(ac)
+		h, err = NewAuthHandlerFunc(ac, opt)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	wellKnown, err := wellKnownRoutes(ac, opt)
+	if err != nil {
+		return nil, err
+	}
+
 	return func(next http.Handler) http.Handler {
 		ah := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if hf, ok := wellKnown[r.URL.Path]; ok {
+				hf(w, r)
+				return
+			}
+
 			if wsAuthSupported && websocket.IsWebSocketUpgrade(r) {
 				next.ServeHTTP(w, r)
 				return
@@ -226,6 +422,137 @@ func NewAuth(ac Auth, log *zap.Logger, opt Options, hFn ...HandlerFunc) (
 	}, nil
 }
 
+// chainedAuth is one entry of a NewAuthChain: the handler built from an
+// Auth config plus the predicate that decides if it applies to a request.
+type chainedAuth struct {
+	ac        Auth
+	h         HandlerFunc
+	wellKnown map[string]http.HandlerFunc
+}
+
+func (ca chainedAuth) matches(r *http.Request) bool {
+	if ca.ac.MatchPath != "" && !strings.HasPrefix(r.URL.Path, ca.ac.MatchPath) {
+		return false
+	}
+	if ca.ac.MatchHeader.Name != "" {
+		v := r.Header.Get(ca.ac.MatchHeader.Name)
+		if v == "" {
+			return false
+		}
+		if ca.ac.MatchHeader.Value != "" && v != ca.ac.MatchHeader.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// NewAuthChain builds a single middleware out of several named Auth
+// configs, for servers that need more than one way to authenticate -
+// e.g. a "header" auth for cron/action endpoints, a "jwt" auth for user
+// traffic, and a "rails" cookie fallback. Configs are tried in order;
+// each is skipped if its MatchPath/MatchHeader predicate doesn't match
+// the request, and otherwise run until one returns a context carrying a
+// user id (see IsAuth); a nil context or one without a user id moves on
+// to the next config.
+// The Auth.Name of whichever config succeeded is recorded under
+// core.AuthNameKey so role rules can gate on $auth_name.
+//
+// TODO: serv.Config (outside this reduced tree) needs a corresponding
+// `Auths []Auth` field, analogous to today's single `Auth Auth` field, so
+// that `serv.ReadInConfig` can opt a server into this instead of NewAuth
+// when more than one entry is configured. That wiring lives in the serv
+// package; until it's added, callers embedding this package have to call
+// NewAuthChain directly instead of getting it for free from config.
+func NewAuthChain(cfgs []Auth, log *zap.Logger, opt Options) (
+	func(next http.Handler) http.Handler, error) {
+	if len(cfgs) == 0 {
+		return nil, ErrNoAuthDefined
+	}
+
+	chain := make([]chainedAuth, len(cfgs))
+
+	for i, ac := range cfgs {
+		h, err := NewAuthHandlerFunc(ac, opt)
+		if err != nil {
+			return nil, fmt.Errorf("auth chain '%s': %w", ac.Name, err)
+		}
+
+		wellKnown, err := wellKnownRoutes(ac, opt)
+		if err != nil {
+			return nil, fmt.Errorf("auth chain '%s': %w", ac.Name, err)
+		}
+
+		chain[i] = chainedAuth{ac: ac, h: h, wellKnown: wellKnown}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, ca := range chain {
+				if hf, ok := ca.wellKnown[r.URL.Path]; ok {
+					hf(w, r)
+					return
+				}
+			}
+
+			var c context.Context
+
+			for _, ca := range chain {
+				if !ca.matches(r) {
+					continue
+				}
+
+				cc, err := ca.h(w, r)
+				if err != nil {
+					if log != nil {
+						log.Error("Auth", []zapcore.Field{
+							zap.String("name", ca.ac.Name),
+							zap.String("type", ca.ac.Type),
+							zap.Error(err),
+						}...)
+					}
+					continue
+				}
+
+				if cc != nil && IsAuth(cc) {
+					c = context.WithValue(cc, core.AuthNameKey, ca.ac.Name)
+					break
+				}
+			}
+
+			if opt.AuthFailBlock && c == nil {
+				http.Error(w, "401 unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if c != nil {
+				next.ServeHTTP(w, r.WithContext(c))
+			} else {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}, nil
+}
+
+// wellKnownRoutes returns the extra HTTP handlers (callback, logout, etc.)
+// an auth type needs registered alongside its HandlerFunc, keyed by path.
+// Types that don't need any return a nil map.
+func wellKnownRoutes(ac Auth, opt Options) (map[string]http.HandlerFunc, error) {
+	if ac.Development {
+		return nil, nil
+	}
+
+	switch ac.Type {
+	case "oidc":
+		return oidcRoutes(ac)
+	case "social":
+		return socialRoutes(ac, opt)
+	case "magiclink":
+		return magicLinkRoutes(ac, opt)
+	default:
+		return nil, nil
+	}
+}
+
 func SimpleHandler(ac Auth) (HandlerFunc, error) {
 	return func(_ http.ResponseWriter, r *http.Request) (context.Context, error) {
 		c := r.Context()
@@ -251,9 +578,13 @@ func SimpleHandler(ac Auth) (HandlerFunc, error) {
 
 var Err401 = errors.New("401 unauthorized")
 
-func HeaderHandler(ac Auth) (HandlerFunc, error) {
+func HeaderHandler(ac Auth, opt Options) (HandlerFunc, error) {
 	hdr := ac.Header
 
+	if hdr.Bearer.Introspection.URL != "" || opt.HeaderBearerVerifier != nil {
+		return BearerHandler(ac, opt)
+	}
+
 	if hdr.Name == "" {
 		return nil, fmt.Errorf("auth '%s': no header.name defined", ac.Name)
 	}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	core "github.com/dosco/graphjin/v2/core"
+)
+
+func TestChainedAuthMatches(t *testing.T) {
+	tests := []struct {
+		name string
+		ac   Auth
+		path string
+		want bool
+	}{
+		{name: "no predicate matches everything", ac: Auth{}, path: "/anything", want: true},
+		{name: "match_path prefix hit", ac: Auth{MatchPath: "/cron"}, path: "/cron/sync", want: true},
+		{name: "match_path prefix miss", ac: Auth{MatchPath: "/cron"}, path: "/graphql", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ca := chainedAuth{ac: tt.ac}
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if got := ca.matches(r); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChainedAuthMatchesHeader(t *testing.T) {
+	ac := Auth{}
+	ac.MatchHeader.Name = "X-Source"
+	ac.MatchHeader.Value = "cron"
+	ca := chainedAuth{ac: ac}
+
+	r := httptest.NewRequest(http.MethodGet, "/graphql", nil)
+	if ca.matches(r) {
+		t.Fatal("matches() = true, want false for missing header")
+	}
+
+	r.Header.Set("X-Source", "cron")
+	if !ca.matches(r) {
+		t.Fatal("matches() = false, want true once the header value matches")
+	}
+
+	r.Header.Set("X-Source", "other")
+	if ca.matches(r) {
+		t.Fatal("matches() = true, want false when the header value doesn't match")
+	}
+}
+
+// TestNewAuthChainPrecedence checks that configs are tried in order and
+// that the first one whose MatchPath/MatchHeader predicate matches wins,
+// even when a later config in the list would also match.
+func TestNewAuthChainPrecedence(t *testing.T) {
+	cron := Auth{Name: "cron", Development: true, MatchPath: "/cron"}
+	catchall := Auth{Name: "catchall", Development: true}
+
+	mw, err := NewAuthChain([]Auth{cron, catchall}, nil, Options{})
+	if err != nil {
+		t.Fatalf("NewAuthChain() error = %v", err)
+	}
+
+	var gotName string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotName, _ = r.Context().Value(core.AuthNameKey).(string)
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/cron/sync", nil)
+	r.Header.Set("X-User-ID", "u1")
+	w := httptest.NewRecorder()
+
+	mw(next).ServeHTTP(w, r)
+
+	if gotName != "cron" {
+		t.Fatalf("auth name = %q, want %q", gotName, "cron")
+	}
+}
@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	core "github.com/dosco/graphjin/v2/core"
+)
+
+const bearerDefaultScheme = "Bearer"
+const bearerDefaultCacheTTL = 30 * time.Second
+
+// ErrBearerTokenNotActive marks a verifier error as a definitive "not active"
+// introspection result, as opposed to a transient failure to reach the
+// introspection endpoint at all (network error, 5xx, ...). Only the
+// former is safe to cache: caching the latter would mark a perfectly
+// valid token unauthorized for up to CacheTTL after a single blip.
+var ErrBearerTokenNotActive = errors.New("token not active")
+
+// bearerCacheEntry is one token's cached introspection result.
+type bearerCacheEntry struct {
+	claims  map[string]interface{}
+	active  bool
+	expires time.Time
+}
+
+// bearerCache is a small LRU + TTL cache keyed by token hash, so a
+// high-traffic bearer auth doesn't round-trip to the introspection
+// endpoint on every request.
+type bearerCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	maxSize int
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type bearerCacheItem struct {
+	key   string
+	entry bearerCacheEntry
+}
+
+func newBearerCache(ttl time.Duration, maxSize int) *bearerCache {
+	return &bearerCache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		entries: map[string]*list.Element{},
+		order:   list.New(),
+	}
+}
+
+func (c *bearerCache) get(key string) (bearerCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return bearerCacheEntry{}, false
+	}
+
+	item := el.Value.(*bearerCacheItem)
+	if time.Now().After(item.entry.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return bearerCacheEntry{}, false
+	}
+
+	c.order.MoveToFront(el)
+	return item.entry, true
+}
+
+func (c *bearerCache) set(key string, entry bearerCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry.expires = time.Now().Add(c.ttl)
+
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*bearerCacheItem).entry = entry
+		c.order.MoveToFront(el)
+		return
+	}
+
+	el := c.order.PushFront(&bearerCacheItem{key: key, entry: entry})
+	c.entries[key] = el
+
+	for c.order.Len() > c.maxSize {
+		oldest := c.order.Back()
+		if oldest == nil {
+			break
+		}
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*bearerCacheItem).key)
+	}
+}
+
+// BearerHandler authenticates "Authorization: <Scheme> <token>" requests
+// by validating the opaque token against an RFC 7662 introspection
+// endpoint, or against opt.HeaderBearerVerifier when one is supplied
+// instead of (or in addition to) Header.Bearer.Introspection.
+func BearerHandler(ac Auth, opt Options) (HandlerFunc, error) {
+	hb := ac.Header.Bearer
+
+	scheme := hb.Scheme
+	if scheme == "" {
+		scheme = bearerDefaultScheme
+	}
+
+	userIDClaim := hb.ClaimUserID
+	if userIDClaim == "" {
+		userIDClaim = "sub"
+	}
+
+	ttl := bearerDefaultCacheTTL
+	if hb.Introspection.CacheTTL != "" {
+		if d, err := time.ParseDuration(hb.Introspection.CacheTTL); err == nil {
+			ttl = d
+		}
+	}
+
+	cache := newBearerCache(ttl, 10000)
+
+	verify := opt.HeaderBearerVerifier
+	if verify == nil {
+		if hb.Introspection.URL == "" {
+			return nil, fmt.Errorf("auth '%s': no header.bearer.introspection.url defined", ac.Name)
+		}
+		verify = introspectVerifier(hb.Introspection.URL, ac)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+		authz := r.Header.Get("Authorization")
+		if !strings.HasPrefix(authz, scheme+" ") {
+			return nil, Err401
+		}
+		token := strings.TrimSpace(strings.TrimPrefix(authz, scheme+" "))
+		if token == "" {
+			return nil, Err401
+		}
+
+		key := tokenCacheKey(token)
+
+		entry, ok := cache.get(key)
+		if !ok {
+			claims, err := verify(r.Context(), token)
+			switch {
+			case err == nil:
+				entry = bearerCacheEntry{claims: claims, active: true}
+				cache.set(key, entry)
+			case errors.Is(err, ErrBearerTokenNotActive):
+				entry = bearerCacheEntry{active: false}
+				cache.set(key, entry)
+			default:
+				// Transient failure (network error, 5xx, ...): don't cache
+				// it, so the next request gets a fresh chance.
+				return nil, Err401
+			}
+		}
+
+		if !entry.active {
+			return nil, Err401
+		}
+
+		c := r.Context()
+		if v, ok := entry.claims[userIDClaim].(string); ok && v != "" {
+			c = context.WithValue(c, core.UserIDKey, v)
+		} else {
+			return nil, Err401
+		}
+
+		if hb.ClaimUserRole != "" {
+			if v, ok := entry.claims[hb.ClaimUserRole].(string); ok && v != "" {
+				c = context.WithValue(c, core.UserRoleKey, v)
+			}
+		}
+
+		return c, nil
+	}, nil
+}
+
+// introspectVerifier calls an RFC 7662 token introspection endpoint and
+// turns its response into the claims map BearerHandler expects.
+func introspectVerifier(introspectionURL string, ac Auth) func(context.Context, string) (map[string]interface{}, error) {
+	return func(ctx context.Context, token string) (map[string]interface{}, error) {
+		form := url.Values{"token": {token}}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, introspectionURL,
+			strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("auth '%s': introspection responded with %d", ac.Name, res.StatusCode)
+		}
+
+		var claims map[string]interface{}
+		if err := json.NewDecoder(res.Body).Decode(&claims); err != nil {
+			return nil, err
+		}
+
+		active, _ := claims["active"].(bool)
+		if !active {
+			return nil, fmt.Errorf("auth '%s': %w", ac.Name, ErrBearerTokenNotActive)
+		}
+
+		return claims, nil
+	}
+}
+
+func tokenCacheKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBearerHandlerDoesNotCacheTransientErrors(t *testing.T) {
+	calls := 0
+	verify := func(_ context.Context, _ string) (map[string]interface{}, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New("connection refused")
+		}
+		return map[string]interface{}{"sub": "user-1"}, nil
+	}
+
+	ac := Auth{Name: "bearer"}
+	h, err := BearerHandler(ac, Options{HeaderBearerVerifier: verify})
+	if err != nil {
+		t.Fatalf("BearerHandler() error = %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer tok123")
+
+	if _, err := h(httptest.NewRecorder(), req); err != Err401 {
+		t.Fatalf("first call error = %v, want Err401", err)
+	}
+	if calls != 1 {
+		t.Fatalf("calls = %d, want 1", calls)
+	}
+
+	c, err := h(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatalf("second call error = %v, want nil", err)
+	}
+	if calls != 2 {
+		t.Fatalf("calls = %d, want 2 - a transient failure must not be cached", calls)
+	}
+	if UserID(c) != "user-1" {
+		t.Fatalf("UserID() = %v, want user-1", UserID(c))
+	}
+}
+
+func TestBearerHandlerCachesDefiniteInactive(t *testing.T) {
+	calls := 0
+	verify := func(_ context.Context, _ string) (map[string]interface{}, error) {
+		calls++
+		return nil, ErrBearerTokenNotActive
+	}
+
+	ac := Auth{Name: "bearer"}
+	h, err := BearerHandler(ac, Options{HeaderBearerVerifier: verify})
+	if err != nil {
+		t.Fatalf("BearerHandler() error = %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer tok123")
+
+	for i := 0; i < 2; i++ {
+		if _, err := h(httptest.NewRecorder(), req); err != Err401 {
+			t.Fatalf("call %d error = %v, want Err401", i, err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("calls = %d, want 1 - a definitive inactive result should be cached", calls)
+	}
+}
@@ -0,0 +1,341 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"sync"
+	"time"
+)
+
+const (
+	magicLinkRequestPath = "/auth/magiclink/request"
+	magicLinkVerifyPath  = "/auth/magiclink/verify"
+
+	magicLinkDefaultTTL = 15 * time.Minute
+
+	// magicLinkDefaultSessionTTL is the minted JWT's lifetime when
+	// MagicLink.CookieExpiry is unset. Kept independent of cookieMaxAge,
+	// whose zero value legitimately means "browser-session cookie", not
+	// "already expired".
+	magicLinkDefaultSessionTTL = 24 * time.Hour
+)
+
+// MagicLinkNonceStore is the pluggable replay-protection backend for the
+// "magiclink" auth type. Reserve must atomically check-and-mark a nonce
+// as used so the same login link can't be redeemed twice, even across
+// instances when backed by something like Redis.
+type MagicLinkNonceStore interface {
+	// Reserve returns true the first time it's called for a given nonce
+	// within ttl of each other, and false on every subsequent call (or on
+	// error, to fail closed).
+	Reserve(ctx context.Context, nonce string, ttl time.Duration) (bool, error)
+}
+
+// MagicLinkMailer sends the login link email for the "magiclink" auth
+// type. Defaults to dialing Auth.MagicLink.SMTPConfig with net/smtp.
+type MagicLinkMailer interface {
+	Send(ctx context.Context, to, from, link string) error
+}
+
+// memoryNonceStore is the default MagicLinkNonceStore: an in-process map
+// good enough for single-instance deployments or tests.
+type memoryNonceStore struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func newMemoryNonceStore() *memoryNonceStore {
+	return &memoryNonceStore{seen: map[string]time.Time{}}
+}
+
+func (s *memoryNonceStore) Reserve(_ context.Context, nonce string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for n, exp := range s.seen {
+		if now.After(exp) {
+			delete(s.seen, n)
+		}
+	}
+
+	if _, ok := s.seen[nonce]; ok {
+		return false, nil
+	}
+	s.seen[nonce] = now.Add(ttl)
+	return true, nil
+}
+
+// smtpMailer is the default MagicLinkMailer, sending plain-text email
+// directly via net/smtp.
+type smtpMailer struct {
+	cfg struct {
+		Host     string
+		Port     int
+		User     string
+		Password string
+	}
+}
+
+func (m smtpMailer) Send(_ context.Context, to, from, link string) error {
+	addr := fmt.Sprintf("%s:%d", m.cfg.Host, m.cfg.Port)
+
+	var auth smtp.Auth
+	if m.cfg.User != "" {
+		auth = smtp.PlainAuth("", m.cfg.User, m.cfg.Password, m.cfg.Host)
+	}
+
+	body := fmt.Sprintf("To: %s\r\nFrom: %s\r\nSubject: Your sign-in link\r\n\r\nSign in: %s\r\n",
+		to, from, link)
+
+	return smtp.SendMail(addr, auth, from, []string{to}, []byte(body))
+}
+
+// MagicLinkHandler authenticates requests the same way the "jwt" auth
+// type does, since /auth/magiclink/verify mints and sets a regular JWT
+// cookie once a login link is redeemed.
+func MagicLinkHandler(ac Auth) (HandlerFunc, error) {
+	return JwtHandler(ac)
+}
+
+type magicLinkHandler struct {
+	ac    Auth
+	ttl   time.Duration
+	store MagicLinkNonceStore
+	mail  MagicLinkMailer
+
+	cookieHTTPS  bool
+	cookieMaxAge int
+	sessionTTL   time.Duration
+}
+
+// magicLinkRoutes returns the /auth/magiclink/request and
+// /auth/magiclink/verify handlers.
+func magicLinkRoutes(ac Auth, opt Options) (map[string]http.HandlerFunc, error) {
+	ml := ac.MagicLink
+
+	if ml.Secret == "" {
+		return nil, fmt.Errorf("auth '%s': no magiclink.secret defined", ac.Name)
+	}
+	if ac.Cookie == "" {
+		return nil, fmt.Errorf("auth '%s': no cookie name defined", ac.Name)
+	}
+
+	ttl := magicLinkDefaultTTL
+	if ml.TokenTTL != "" {
+		if d, err := time.ParseDuration(ml.TokenTTL); err == nil {
+			ttl = d
+		}
+	}
+
+	maxAge := 0
+	sessionTTL := magicLinkDefaultSessionTTL
+	if ml.CookieExpiry != "" {
+		if d, err := time.ParseDuration(ml.CookieExpiry); err == nil {
+			maxAge = int(d.Seconds())
+			sessionTTL = d
+		}
+	}
+
+	store := opt.MagicLinkNonceStore
+	if store == nil {
+		store = newMemoryNonceStore()
+	}
+
+	mail := opt.Mailer
+	if mail == nil {
+		m := smtpMailer{}
+		m.cfg.Host = ml.SMTPConfig.Host
+		m.cfg.Port = ml.SMTPConfig.Port
+		m.cfg.User = ml.SMTPConfig.User
+		m.cfg.Password = ml.SMTPConfig.Password
+		mail = m
+	}
+
+	h := &magicLinkHandler{
+		ac:           ac,
+		ttl:          ttl,
+		store:        store,
+		mail:         mail,
+		cookieHTTPS:  ml.CookieHTTPS,
+		cookieMaxAge: maxAge,
+		sessionTTL:   sessionTTL,
+	}
+
+	return map[string]http.HandlerFunc{
+		magicLinkRequestPath: h.request,
+		magicLinkVerifyPath:  h.verify,
+	}, nil
+}
+
+// request mails a signed, single-use login link to the given email.
+func (h *magicLinkHandler) request(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Email == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	token, err := h.issueToken(body.Email)
+	if err != nil {
+		http.Error(w, "failed to issue login link", http.StatusInternalServerError)
+		return
+	}
+
+	link := fmt.Sprintf("https://%s%s?token=%s", r.Host, magicLinkVerifyPath, token)
+
+	if err := h.mail.Send(r.Context(), body.Email, h.ac.MagicLink.FromEmail, link); err != nil {
+		http.Error(w, "failed to send login link", http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// verify redeems a login link token and, on success, mints a JWT cookie
+// so subsequent requests authenticate via the normal "jwt" path.
+func (h *magicLinkHandler) verify(w http.ResponseWriter, r *http.Request) {
+	email, err := h.redeemToken(r.Context(), r.URL.Query().Get("token"))
+	if err != nil {
+		http.Error(w, "401 unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	jwt, err := signHS256JWT(h.ac.JWT, map[string]interface{}{
+		"sub": email,
+		"exp": time.Now().Add(h.sessionTTL).Unix(),
+	})
+	if err != nil {
+		http.Error(w, "failed to mint session", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     h.ac.Cookie,
+		Value:    jwt,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   h.cookieHTTPS,
+		MaxAge:   h.cookieMaxAge,
+	})
+
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// magicLinkPayload is the JSON structure base64-encoded into a token's
+// payload segment. JSON (rather than a naive delimiter) is used because
+// the local part of an email address can legally contain any delimiter
+// we might otherwise pick, e.g. "|".
+type magicLinkPayload struct {
+	Email string `json:"email"`
+	Nonce string `json:"nonce"`
+	Exp   int64  `json:"exp"`
+}
+
+// issueToken builds a "<payload>.<signature>" token where payload base64
+// encodes the email, a random nonce and the expiry as JSON, and signature
+// is an HMAC-SHA256 over the payload keyed on MagicLink.Secret.
+func (h *magicLinkHandler) issueToken(email string) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(magicLinkPayload{
+		Email: email,
+		Nonce: base64.RawURLEncoding.EncodeToString(nonce),
+		Exp:   time.Now().Add(h.ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding.EncodeToString(payload)
+
+	mac := hmac.New(sha256.New, []byte(h.ac.MagicLink.Secret))
+	mac.Write([]byte(enc))
+	sig := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+	return enc + "." + sig, nil
+}
+
+// redeemToken validates the token's signature and expiry, and rejects it
+// if its nonce has already been used.
+func (h *magicLinkHandler) redeemToken(ctx context.Context, token string) (string, error) {
+	parts := splitToken(token)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("malformed token")
+	}
+	enc, sig := parts[0], parts[1]
+
+	mac := hmac.New(sha256.New, []byte(h.ac.MagicLink.Secret))
+	mac.Write([]byte(enc))
+	want := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+	if subtle.ConstantTimeCompare([]byte(sig), []byte(want)) != 1 {
+		return "", fmt.Errorf("signature mismatch")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil {
+		return "", err
+	}
+
+	var p magicLinkPayload
+	if err := json.Unmarshal(payload, &p); err != nil || p.Email == "" {
+		return "", fmt.Errorf("malformed payload")
+	}
+
+	if time.Now().Unix() > p.Exp {
+		return "", fmt.Errorf("token expired")
+	}
+
+	fresh, err := h.store.Reserve(ctx, p.Nonce, h.ttl)
+	if err != nil || !fresh {
+		return "", fmt.Errorf("token already used")
+	}
+
+	return p.Email, nil
+}
+
+func splitToken(s string) []string {
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] == '.' {
+			return []string{s[:i], s[i+1:]}
+		}
+	}
+	return []string{s}
+}
+
+// signHS256JWT mints a minimal HS256 JWT from the given claims, signed
+// with the same secret configured for the "jwt" auth type so the
+// existing JwtHandler can verify it without any changes.
+func signHS256JWT(jc JWTConfig, claims map[string]interface{}) (string, error) {
+	header := map[string]string{"alg": "HS256", "typ": "JWT"}
+
+	hb, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	cb, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(hb) + "." + base64.RawURLEncoding.EncodeToString(cb)
+
+	mac := hmac.New(sha256.New, []byte(jc.Secret))
+	mac.Write([]byte(signingInput))
+	sig := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+	return signingInput + "." + sig, nil
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newTestMagicLinkHandler(ttl time.Duration) *magicLinkHandler {
+	ac := Auth{Name: "magiclink"}
+	ac.MagicLink.Secret = "test-secret"
+
+	return &magicLinkHandler{
+		ac:    ac,
+		ttl:   ttl,
+		store: newMemoryNonceStore(),
+	}
+}
+
+func TestMagicLinkIssueRedeem(t *testing.T) {
+	h := newTestMagicLinkHandler(magicLinkDefaultTTL)
+
+	token, err := h.issueToken("user@example.com")
+	if err != nil {
+		t.Fatalf("issueToken() error = %v", err)
+	}
+
+	email, err := h.redeemToken(context.Background(), token)
+	if err != nil {
+		t.Fatalf("redeemToken() error = %v", err)
+	}
+	if email != "user@example.com" {
+		t.Fatalf("redeemToken() email = %q, want %q", email, "user@example.com")
+	}
+}
+
+func TestMagicLinkRedeemRejectsReplay(t *testing.T) {
+	h := newTestMagicLinkHandler(magicLinkDefaultTTL)
+
+	token, err := h.issueToken("user@example.com")
+	if err != nil {
+		t.Fatalf("issueToken() error = %v", err)
+	}
+
+	if _, err := h.redeemToken(context.Background(), token); err != nil {
+		t.Fatalf("first redeemToken() error = %v", err)
+	}
+
+	if _, err := h.redeemToken(context.Background(), token); err == nil {
+		t.Fatal("second redeemToken() of the same token succeeded, want error")
+	}
+}
+
+func TestMagicLinkRedeemRejectsExpired(t *testing.T) {
+	h := newTestMagicLinkHandler(-time.Minute)
+
+	token, err := h.issueToken("user@example.com")
+	if err != nil {
+		t.Fatalf("issueToken() error = %v", err)
+	}
+
+	if _, err := h.redeemToken(context.Background(), token); err == nil {
+		t.Fatal("redeemToken() of an expired token succeeded, want error")
+	}
+}
+
+func TestMagicLinkIssueRedeemEmailWithDelimiterChar(t *testing.T) {
+	h := newTestMagicLinkHandler(magicLinkDefaultTTL)
+
+	const email = `a|b@example.com`
+
+	token, err := h.issueToken(email)
+	if err != nil {
+		t.Fatalf("issueToken() error = %v", err)
+	}
+
+	got, err := h.redeemToken(context.Background(), token)
+	if err != nil {
+		t.Fatalf("redeemToken() error = %v", err)
+	}
+	if got != email {
+		t.Fatalf("redeemToken() email = %q, want %q", got, email)
+	}
+}
+
+func TestMagicLinkRedeemRejectsTamperedSignature(t *testing.T) {
+	h := newTestMagicLinkHandler(magicLinkDefaultTTL)
+
+	token, err := h.issueToken("user@example.com")
+	if err != nil {
+		t.Fatalf("issueToken() error = %v", err)
+	}
+
+	parts := splitToken(token)
+	tampered := parts[0] + "." + parts[1] + "x"
+
+	if _, err := h.redeemToken(context.Background(), tampered); err == nil {
+		t.Fatal("redeemToken() of a tampered token succeeded, want error")
+	}
+}
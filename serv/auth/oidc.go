@@ -0,0 +1,632 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	core "github.com/dosco/graphjin/v2/core"
+)
+
+const (
+	oidcLoginPath    = "/auth/oidc/login"
+	oidcCallbackPath = "/auth/oidc/callback"
+	oidcLogoutPath   = "/auth/oidc/logout"
+
+	oidcCookieName      = "gj_oidc"
+	oidcStateCookieName = "gj_oidc_state"
+	oidcCookieMaxLen    = 4000 // stay under the ~4KB per-cookie browser limit
+	oidcDefaultSkew     = 30 * time.Second
+)
+
+// oidcSession is what we encrypt and store, split across cookies, between
+// requests. It holds just enough to refresh the token set without having to
+// hit the issuer's userinfo endpoint again.
+type oidcSession struct {
+	AccessToken  string    `json:"at"`
+	RefreshToken string    `json:"rt"`
+	IDToken      string    `json:"it"`
+	Expiry       time.Time `json:"exp"`
+}
+
+type oidcDiscovery struct {
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+// oidcRoutes returns the login, callback and logout handlers NewAuth registers
+// on well-known paths for the "oidc" auth type.
+func oidcRoutes(ac Auth) (map[string]http.HandlerFunc, error) {
+	o, err := newOIDCHandler(ac)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]http.HandlerFunc{
+		oidcLoginPath:    o.login,
+		oidcCallbackPath: o.callback,
+		oidcLogoutPath:   o.logout,
+	}, nil
+}
+
+// OIDCHandler returns a HandlerFunc that authenticates requests using an
+// OpenID Connect authorization-code flow. On requests where the access
+// token is within RefreshSkew of expiry it is transparently refreshed
+// against the issuer's token endpoint before the request continues.
+func OIDCHandler(ac Auth) (HandlerFunc, error) {
+	o, err := newOIDCHandler(ac)
+	if err != nil {
+		return nil, err
+	}
+	return o.authenticate, nil
+}
+
+type oidcHandler struct {
+	ac           Auth
+	disc         oidcDiscovery
+	jwks         *oidcJWKS
+	key          [32]byte
+	scopes       []string
+	redirectURL  string
+	cookieHTTPS  bool
+	cookieMaxAge int
+	skew         time.Duration
+	userIDClaim  string
+	roleClaim    string
+}
+
+func newOIDCHandler(ac Auth) (*oidcHandler, error) {
+	oc := ac.OIDC
+
+	if oc.Issuer == "" {
+		return nil, fmt.Errorf("auth '%s': no oidc.issuer defined", ac.Name)
+	}
+	if oc.ClientID == "" {
+		return nil, fmt.Errorf("auth '%s': no oidc.client_id defined", ac.Name)
+	}
+
+	disc, err := discoverOIDC(oc.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("auth '%s': oidc discovery: %w", ac.Name, err)
+	}
+
+	key, err := decodeCookieSecret(oc.CookieSecret)
+	if err != nil {
+		return nil, fmt.Errorf("auth '%s': oidc.cookie_secret: %w", ac.Name, err)
+	}
+
+	scopes := oc.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+
+	skew := oidcDefaultSkew
+	if oc.RefreshSkew != "" {
+		if d, err := time.ParseDuration(oc.RefreshSkew); err == nil {
+			skew = d
+		}
+	}
+
+	maxAge := 0
+	if oc.CookieExpiry != "" {
+		if d, err := time.ParseDuration(oc.CookieExpiry); err == nil {
+			maxAge = int(d.Seconds())
+		}
+	}
+
+	userIDClaim := oc.UserIDClaim
+	if userIDClaim == "" {
+		userIDClaim = "sub"
+	}
+
+	return &oidcHandler{
+		ac:           ac,
+		disc:         disc,
+		jwks:         newOIDCJWKS(disc.JWKSURI),
+		key:          key,
+		scopes:       scopes,
+		redirectURL:  oc.RedirectURL,
+		cookieHTTPS:  oc.CookieHTTPS,
+		cookieMaxAge: maxAge,
+		skew:         skew,
+		userIDClaim:  userIDClaim,
+		roleClaim:    oc.UserRoleClaim,
+	}, nil
+}
+
+// authenticate validates (and if needed refreshes) the session cookie and
+// populates the request context with the user id/role claims.
+func (o *oidcHandler) authenticate(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+	sess, ok := o.readSession(r)
+	if !ok {
+		return nil, Err401
+	}
+
+	if time.Until(sess.Expiry) < o.skew {
+		refreshed, err := o.refresh(sess.RefreshToken)
+		if err != nil {
+			return nil, Err401
+		}
+		sess = refreshed
+		o.writeSession(w, r, sess)
+	}
+
+	claims, err := o.jwks.validate(sess.IDToken, o.ac.OIDC.ClientID)
+	if err != nil {
+		return nil, Err401
+	}
+
+	c := r.Context()
+	if v, ok := claims[o.userIDClaim].(string); ok && v != "" {
+		c = context.WithValue(c, core.UserIDKey, v)
+	} else {
+		return nil, Err401
+	}
+
+	if o.roleClaim != "" {
+		if v, ok := claims[o.roleClaim].(string); ok && v != "" {
+			c = context.WithValue(c, core.UserRoleKey, v)
+		}
+	}
+
+	return c, nil
+}
+
+// login redirects the browser to the issuer's authorization endpoint to
+// start the authorization-code flow. A random state value is stashed in a
+// short-lived cookie and echoed back by the issuer so callback can refuse
+// to complete a flow it didn't start (login CSRF).
+func (o *oidcHandler) login(w http.ResponseWriter, r *http.Request) {
+	state := randomState()
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    state,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   o.cookieHTTPS,
+		MaxAge:   int((10 * time.Minute).Seconds()),
+	})
+
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id":     {o.ac.OIDC.ClientID},
+		"redirect_uri":  {o.redirectURL},
+		"scope":         {strings.Join(o.scopes, " ")},
+		"state":         {state},
+	}
+	http.Redirect(w, r, o.disc.AuthorizationEndpoint+"?"+q.Encode(), http.StatusFound)
+}
+
+// callback exchanges the authorization code for a token set, stores it in
+// the session cookie and redirects the browser to "/".
+func (o *oidcHandler) callback(w http.ResponseWriter, r *http.Request) {
+	stateCk, err := r.Cookie(oidcStateCookieName)
+	if err != nil || r.URL.Query().Get("state") != stateCk.Value {
+		http.Error(w, "invalid state", http.StatusBadRequest)
+		return
+	}
+
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		http.Error(w, "missing code", http.StatusBadRequest)
+		return
+	}
+
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {o.redirectURL},
+		"client_id":    {o.ac.OIDC.ClientID},
+	}
+
+	sess, err := o.exchangeToken(form)
+	if err != nil {
+		http.Error(w, "token exchange failed", http.StatusBadGateway)
+		return
+	}
+
+	o.writeSession(w, r, sess)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// logout clears the session cookie(s) and, when the issuer supports RP
+// initiated logout, redirects to its end_session_endpoint.
+func (o *oidcHandler) logout(w http.ResponseWriter, r *http.Request) {
+	o.clearSession(w)
+
+	if o.disc.EndSessionEndpoint != "" {
+		http.Redirect(w, r, o.disc.EndSessionEndpoint, http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+func (o *oidcHandler) refresh(refreshToken string) (oidcSession, error) {
+	if refreshToken == "" {
+		return oidcSession{}, fmt.Errorf("no refresh token")
+	}
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+		"client_id":     {o.ac.OIDC.ClientID},
+	}
+	return o.exchangeToken(form)
+}
+
+func (o *oidcHandler) exchangeToken(form url.Values) (oidcSession, error) {
+	if o.ac.OIDC.ClientSecret != "" {
+		form.Set("client_secret", o.ac.OIDC.ClientSecret)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, o.disc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return oidcSession{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return oidcSession{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return oidcSession{}, fmt.Errorf("token endpoint responded with %d", res.StatusCode)
+	}
+
+	var tr struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		IDToken      string `json:"id_token"`
+		ExpiresIn    int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tr); err != nil {
+		return oidcSession{}, err
+	}
+
+	return oidcSession{
+		AccessToken:  tr.AccessToken,
+		RefreshToken: tr.RefreshToken,
+		IDToken:      tr.IDToken,
+		Expiry:       time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second),
+	}, nil
+}
+
+func discoverOIDC(issuer string) (oidcDiscovery, error) {
+	var disc oidcDiscovery
+
+	res, err := http.Get(strings.TrimRight(issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return disc, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return disc, fmt.Errorf("discovery endpoint responded with %d", res.StatusCode)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&disc); err != nil {
+		return disc, err
+	}
+	return disc, nil
+}
+
+// readSession reassembles the (possibly split) encrypted session cookie
+// and decodes it.
+func (o *oidcHandler) readSession(r *http.Request) (oidcSession, bool) {
+	var buf strings.Builder
+
+	for i := 0; ; i++ {
+		ck, err := r.Cookie(fmt.Sprintf("%s_%d", oidcCookieName, i))
+		if err != nil {
+			break
+		}
+		buf.WriteString(ck.Value)
+	}
+
+	if buf.Len() == 0 {
+		return oidcSession{}, false
+	}
+
+	plain, err := decryptCookie(o.key, buf.String())
+	if err != nil {
+		return oidcSession{}, false
+	}
+
+	var sess oidcSession
+	if err := json.Unmarshal(plain, &sess); err != nil {
+		return oidcSession{}, false
+	}
+	return sess, true
+}
+
+// writeSession encrypts the session and splits it across as many
+// "<name>_0", "<name>_1", ... cookies as needed to stay under the
+// per-cookie size limit. Any higher-indexed cookies left over from a
+// previous, larger session are cleared so readSession doesn't concatenate
+// stale ciphertext onto the new one.
+func (o *oidcHandler) writeSession(w http.ResponseWriter, r *http.Request, sess oidcSession) {
+	plain, err := json.Marshal(sess)
+	if err != nil {
+		return
+	}
+
+	enc, err := encryptCookie(o.key, plain)
+	if err != nil {
+		return
+	}
+
+	i := 0
+	for ; len(enc) > 0; i++ {
+		n := len(enc)
+		if n > oidcCookieMaxLen {
+			n = oidcCookieMaxLen
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     fmt.Sprintf("%s_%d", oidcCookieName, i),
+			Value:    enc[:n],
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   o.cookieHTTPS,
+			MaxAge:   o.cookieMaxAge,
+		})
+		enc = enc[n:]
+	}
+
+	for ; ; i++ {
+		if _, err := r.Cookie(fmt.Sprintf("%s_%d", oidcCookieName, i)); err != nil {
+			break
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     fmt.Sprintf("%s_%d", oidcCookieName, i),
+			Value:    "",
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   o.cookieHTTPS,
+			MaxAge:   -1,
+		})
+	}
+}
+
+func (o *oidcHandler) clearSession(w http.ResponseWriter) {
+	for i := 0; i < 10; i++ {
+		http.SetCookie(w, &http.Cookie{
+			Name:     fmt.Sprintf("%s_%d", oidcCookieName, i),
+			Value:    "",
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   o.cookieHTTPS,
+			MaxAge:   -1,
+		})
+	}
+}
+
+func decodeCookieSecret(s string) ([32]byte, error) {
+	var key [32]byte
+
+	if s == "" {
+		return key, fmt.Errorf("cookie secret is required")
+	}
+
+	if b, err := hex.DecodeString(s); err == nil && len(b) == 32 {
+		copy(key[:], b)
+		return key, nil
+	}
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil && len(b) == 32 {
+		copy(key[:], b)
+		return key, nil
+	}
+	return key, fmt.Errorf("must be a 32-byte hex or base64 encoded value")
+}
+
+func encryptCookie(key [32]byte, plain []byte) (string, error) {
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	ct := gcm.Seal(nonce, nonce, plain, nil)
+	return base64.RawURLEncoding.EncodeToString(ct), nil
+}
+
+func decryptCookie(key [32]byte, enc string) ([]byte, error) {
+	ct, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	ns := gcm.NonceSize()
+	if len(ct) < ns {
+		return nil, fmt.Errorf("cookie too short")
+	}
+
+	return gcm.Open(nil, ct[:ns], ct[ns:], nil)
+}
+
+// oidcJWKS fetches and caches an issuer's JSON Web Key Set, validating
+// RS256-signed ID tokens against it.
+type oidcJWKS struct {
+	url string
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+func newOIDCJWKS(jwksURL string) *oidcJWKS {
+	return &oidcJWKS{url: jwksURL, keys: map[string]*rsa.PublicKey{}}
+}
+
+func (j *oidcJWKS) validate(idToken, audience string) (map[string]interface{}, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed id token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(hb, &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported alg: %s", header.Alg)
+	}
+
+	key, err := j.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+		return nil, fmt.Errorf("signature verification failed: %w", err)
+	}
+
+	cb, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := json.Unmarshal(cb, &claims); err != nil {
+		return nil, err
+	}
+
+	if exp, ok := claims["exp"].(float64); ok && time.Unix(int64(exp), 0).Before(time.Now()) {
+		return nil, errors.New("id token expired")
+	}
+
+	if audience != "" && !audienceContains(claims["aud"], audience) {
+		return nil, errors.New("id token audience mismatch")
+	}
+
+	return claims, nil
+}
+
+// audienceContains reports whether audience is present in an "aud" claim,
+// which per the OIDC spec may be either a single JSON string or an array
+// of strings when the ID token has multiple audiences.
+func audienceContains(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (j *oidcJWKS) key(kid string) (*rsa.PublicKey, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if k, ok := j.keys[kid]; ok && time.Since(j.fetched) < time.Hour {
+		return k, nil
+	}
+
+	if err := j.refresh(); err != nil {
+		return nil, err
+	}
+
+	k, ok := j.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown jwks kid: %s", kid)
+	}
+	return k, nil
+}
+
+func (j *oidcJWKS) refresh() error {
+	res, err := http.Get(j.url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&jwks); err != nil {
+		return err
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+
+		nb, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		eb, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(nb),
+			E: int(new(big.Int).SetBytes(eb).Int64()),
+		}
+	}
+
+	j.keys = keys
+	j.fetched = time.Now()
+	return nil
+}
@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signTestIDToken mints a minimal RS256 JWT the way a real OIDC issuer
+// would, so oidcJWKS.validate can be exercised without a live issuer.
+func signTestIDToken(t *testing.T, priv *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	t.Helper()
+
+	header := map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid}
+	hb, err := json.Marshal(header)
+	if err != nil {
+		t.Fatalf("marshal header: %v", err)
+	}
+	cb, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("marshal claims: %v", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(hb) + "." + base64.RawURLEncoding.EncodeToString(cb)
+	sum := sha256.Sum256([]byte(signingInput))
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatalf("sign id token: %v", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func newTestJWKS(t *testing.T, priv *rsa.PrivateKey, kid string) *oidcJWKS {
+	t.Helper()
+	j := newOIDCJWKS("")
+	j.keys[kid] = &priv.PublicKey
+	j.fetched = time.Now()
+	return j
+}
+
+func TestOIDCJWKSValidateAcceptsValidToken(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	j := newTestJWKS(t, priv, "kid-1")
+
+	token := signTestIDToken(t, priv, "kid-1", map[string]interface{}{
+		"sub": "user-1",
+		"aud": "client-123",
+		"exp": float64(time.Now().Add(time.Hour).Unix()),
+	})
+
+	claims, err := j.validate(token, "client-123")
+	if err != nil {
+		t.Fatalf("validate() error = %v", err)
+	}
+	if claims["sub"] != "user-1" {
+		t.Fatalf("claims[sub] = %v, want user-1", claims["sub"])
+	}
+}
+
+func TestOIDCJWKSValidateAcceptsAudienceArray(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	j := newTestJWKS(t, priv, "kid-1")
+
+	token := signTestIDToken(t, priv, "kid-1", map[string]interface{}{
+		"sub": "user-1",
+		"aud": []string{"other-client", "client-123"},
+		"exp": float64(time.Now().Add(time.Hour).Unix()),
+	})
+
+	if _, err := j.validate(token, "client-123"); err != nil {
+		t.Fatalf("validate() error = %v, want nil for a matching audience array", err)
+	}
+}
+
+func TestOIDCJWKSValidateRejectsWrongAudience(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	j := newTestJWKS(t, priv, "kid-1")
+
+	token := signTestIDToken(t, priv, "kid-1", map[string]interface{}{
+		"sub": "user-1",
+		"aud": []string{"some-other-client"},
+		"exp": float64(time.Now().Add(time.Hour).Unix()),
+	})
+
+	if _, err := j.validate(token, "client-123"); err == nil {
+		t.Fatal("validate() succeeded for a token not issued to this audience, want error")
+	}
+}
+
+func TestOIDCJWKSValidateRejectsTamperedSignature(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	j := newTestJWKS(t, priv, "kid-1")
+
+	token := signTestIDToken(t, priv, "kid-1", map[string]interface{}{
+		"sub": "user-1",
+		"aud": "client-123",
+		"exp": float64(time.Now().Add(time.Hour).Unix()),
+	})
+	// Flip a byte of the decoded signature rather than swapping its last
+	// base64 character, whose low bits the lenient decoder ignores.
+	parts := strings.Split(token, ".")
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatalf("decode signature: %v", err)
+	}
+	sig[0] ^= 0xff
+	tampered := parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString(sig)
+
+	if _, err := j.validate(tampered, "client-123"); err == nil {
+		t.Fatal("validate() succeeded for a tampered signature, want error")
+	}
+}
+
+func TestEncryptDecryptCookieRoundTrip(t *testing.T) {
+	var key [32]byte
+	copy(key[:], []byte("0123456789abcdef0123456789abcdef"))
+
+	plain := []byte(`{"at":"access","rt":"refresh","it":"idtoken"}`)
+
+	enc, err := encryptCookie(key, plain)
+	if err != nil {
+		t.Fatalf("encryptCookie() error = %v", err)
+	}
+
+	got, err := decryptCookie(key, enc)
+	if err != nil {
+		t.Fatalf("decryptCookie() error = %v", err)
+	}
+
+	if !bytes.Equal(got, plain) {
+		t.Fatalf("decryptCookie() = %q, want %q", got, plain)
+	}
+}
+
+func TestDecryptCookieRejectsWrongKey(t *testing.T) {
+	var key1, key2 [32]byte
+	copy(key1[:], []byte("0123456789abcdef0123456789abcdef"))
+	copy(key2[:], []byte("fedcba9876543210fedcba9876543210"))
+
+	enc, err := encryptCookie(key1, []byte("secret session"))
+	if err != nil {
+		t.Fatalf("encryptCookie() error = %v", err)
+	}
+
+	if _, err := decryptCookie(key2, enc); err == nil {
+		t.Fatal("decryptCookie() with the wrong key succeeded, want error")
+	}
+}
+
+func TestDecryptCookieRejectsTamperedCiphertext(t *testing.T) {
+	var key [32]byte
+	copy(key[:], []byte("0123456789abcdef0123456789abcdef"))
+
+	enc, err := encryptCookie(key, []byte("secret session"))
+	if err != nil {
+		t.Fatalf("encryptCookie() error = %v", err)
+	}
+
+	ct, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil {
+		t.Fatalf("decode ciphertext: %v", err)
+	}
+	ct[len(ct)-1] ^= 0xff
+	tampered := base64.RawURLEncoding.EncodeToString(ct)
+
+	if _, err := decryptCookie(key, tampered); err == nil {
+		t.Fatal("decryptCookie() of tampered ciphertext succeeded, want error")
+	}
+}
@@ -0,0 +1,136 @@
+// Package social provides the OAuth2 endpoint definitions and userinfo
+// parsing needed to sign users in through external identity providers
+// (GitHub, GitLab, Google, ...). It knows nothing about GraphJin's own
+// user/session model — that linking logic lives in serv/auth.
+package social
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+)
+
+// Identity is the provider-agnostic result of a successful OAuth2
+// callback, extracted from the provider's userinfo endpoint.
+type Identity struct {
+	ExternalID string
+	Email      string
+	Name       string
+}
+
+// Provider bundles the OAuth2 endpoint and userinfo plumbing for a single
+// external identity provider.
+type Provider struct {
+	Name          string
+	Endpoint      oauth2.Endpoint
+	UserInfoURL   string
+	DefaultScopes []string
+
+	// parse extracts an Identity from the raw userinfo response body,
+	// since every provider shapes its response differently
+	parse func([]byte) (Identity, error)
+}
+
+// Config returns an oauth2.Config for this provider using the caller's
+// client id/secret, scopes and redirect URL.
+func (p Provider) Config(clientID, clientSecret, redirectURL string, scopes []string) *oauth2.Config {
+	if len(scopes) == 0 {
+		scopes = p.DefaultScopes
+	}
+	return &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       scopes,
+		Endpoint:     p.Endpoint,
+	}
+}
+
+// FetchIdentity calls the provider's userinfo endpoint through the given
+// client, which must carry the access token (e.g. from oauth2.Config.Client),
+// and parses out a provider-agnostic Identity.
+func (p Provider) FetchIdentity(client *http.Client) (Identity, error) {
+	req, err := http.NewRequest(http.MethodGet, p.UserInfoURL, nil)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return Identity{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return Identity{}, fmt.Errorf("social '%s': userinfo responded with %d", p.Name, res.StatusCode)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	return p.parse(body)
+}
+
+// Providers holds the built-in provider definitions keyed by the name
+// used in Auth.Social.Providers config.
+var Providers = map[string]Provider{
+	"github": {
+		Name:          "github",
+		Endpoint:      endpoints.GitHub,
+		UserInfoURL:   "https://api.github.com/user",
+		DefaultScopes: []string{"read:user", "user:email"},
+		parse: func(b []byte) (Identity, error) {
+			var v struct {
+				ID    int    `json:"id"`
+				Login string `json:"login"`
+				Email string `json:"email"`
+				Name  string `json:"name"`
+			}
+			if err := json.Unmarshal(b, &v); err != nil {
+				return Identity{}, err
+			}
+			return Identity{ExternalID: fmt.Sprintf("%d", v.ID), Email: v.Email, Name: v.Name}, nil
+		},
+	},
+	"gitlab": {
+		Name:          "gitlab",
+		Endpoint:      endpoints.GitLab,
+		UserInfoURL:   "https://gitlab.com/api/v4/user",
+		DefaultScopes: []string{"read_user"},
+		parse: func(b []byte) (Identity, error) {
+			var v struct {
+				ID       int    `json:"id"`
+				Email    string `json:"email"`
+				Username string `json:"username"`
+				Name     string `json:"name"`
+			}
+			if err := json.Unmarshal(b, &v); err != nil {
+				return Identity{}, err
+			}
+			return Identity{ExternalID: fmt.Sprintf("%d", v.ID), Email: v.Email, Name: v.Name}, nil
+		},
+	},
+	"google": {
+		Name:          "google",
+		Endpoint:      endpoints.Google,
+		UserInfoURL:   "https://www.googleapis.com/oauth2/v3/userinfo",
+		DefaultScopes: []string{"openid", "profile", "email"},
+		parse: func(b []byte) (Identity, error) {
+			var v struct {
+				Sub   string `json:"sub"`
+				Email string `json:"email"`
+				Name  string `json:"name"`
+			}
+			if err := json.Unmarshal(b, &v); err != nil {
+				return Identity{}, err
+			}
+			return Identity{ExternalID: v.Sub, Email: v.Email, Name: v.Name}, nil
+		},
+	},
+}
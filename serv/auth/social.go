@@ -0,0 +1,315 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	core "github.com/dosco/graphjin/v2/core"
+	"github.com/dosco/graphjin/v2/serv/auth/provider/social"
+	"golang.org/x/oauth2"
+)
+
+const socialCookieName = "gj_social"
+const socialStateCookieName = "gj_social_state"
+
+// SocialUnlinkedLoginFunc decides what happens when a social provider
+// callback succeeds for an external identity that isn't linked to any
+// local user yet. It may auto-create a user and return its id, or return
+// an error to reject the login; the callback then answers 403 Forbidden
+// with the error's message as the body.
+type SocialUnlinkedLoginFunc func(ctx context.Context, provider string, id social.Identity) (userID string, err error)
+
+// socialHandler implements the "social" auth type: it validates the
+// session cookie issued after a successful provider callback and serves
+// the per-provider login/callback routes. LinkExternal and UnlinkExternal
+// are plain functions that reach it through the request context.
+type socialHandler struct {
+	ac  Auth
+	db  *sql.DB
+	key [32]byte
+
+	onUnlinked SocialUnlinkedLoginFunc
+
+	cookieHTTPS  bool
+	cookieMaxAge int
+}
+
+// socialHandlerCtxKey is the context key under which SocialHandler stashes
+// the *socialHandler that authenticated the request, so LinkExternal and
+// UnlinkExternal can reach the db/provider config for that specific auth
+// chain entry instead of a single process-global one.
+type socialHandlerCtxKey struct{}
+
+func socialHandlerFromContext(ctx context.Context) *socialHandler {
+	sh, _ := ctx.Value(socialHandlerCtxKey{}).(*socialHandler)
+	return sh
+}
+
+// socialRoutes returns the /auth/{provider}/login and
+// /auth/{provider}/callback handlers for every provider configured under
+// Auth.Social.Providers.
+func socialRoutes(ac Auth, opt Options) (map[string]http.HandlerFunc, error) {
+	if len(ac.Social.Providers) == 0 {
+		return nil, fmt.Errorf("auth '%s': no social.providers configured", ac.Name)
+	}
+	if opt.DB == nil {
+		return nil, fmt.Errorf("auth '%s': social auth requires Options.DB", ac.Name)
+	}
+
+	key, err := decodeCookieSecret(ac.Social.CookieSecret)
+	if err != nil {
+		return nil, fmt.Errorf("auth '%s': social.cookie_secret: %w", ac.Name, err)
+	}
+
+	maxAge := 0
+	if ac.Social.CookieExpiry != "" {
+		if d, err := time.ParseDuration(ac.Social.CookieExpiry); err == nil {
+			maxAge = int(d.Seconds())
+		}
+	}
+
+	sh := &socialHandler{
+		ac:           ac,
+		db:           opt.DB,
+		key:          key,
+		onUnlinked:   opt.OnUnlinkedLogin,
+		cookieHTTPS:  ac.Social.CookieHTTPS,
+		cookieMaxAge: maxAge,
+	}
+
+	routes := make(map[string]http.HandlerFunc, len(ac.Social.Providers)*2)
+
+	for name, pc := range ac.Social.Providers {
+		p, ok := social.Providers[name]
+		if !ok {
+			return nil, fmt.Errorf("auth '%s': unknown social provider: %s", ac.Name, name)
+		}
+
+		cfg := p.Config(pc.ClientID, pc.ClientSecret, pc.RedirectURL, pc.Scopes)
+		routes["/auth/"+name+"/login"] = sh.login(p, cfg)
+		routes["/auth/"+name+"/callback"] = sh.callback(p, cfg)
+	}
+
+	return routes, nil
+}
+
+// SocialHandler authenticates requests using the session cookie issued
+// after a successful social login callback. The *socialHandler for this
+// auth config is stashed in the returned context next to core.UserIDKey
+// so LinkExternal/UnlinkExternal can reach its db and provider config.
+func SocialHandler(ac Auth, opt Options) (HandlerFunc, error) {
+	key, err := decodeCookieSecret(ac.Social.CookieSecret)
+	if err != nil {
+		return nil, fmt.Errorf("auth '%s': social.cookie_secret: %w", ac.Name, err)
+	}
+
+	sh := &socialHandler{
+		ac:         ac,
+		db:         opt.DB,
+		key:        key,
+		onUnlinked: opt.OnUnlinkedLogin,
+	}
+
+	return func(_ http.ResponseWriter, r *http.Request) (context.Context, error) {
+		ck, err := r.Cookie(socialCookieName)
+		if err != nil {
+			return nil, Err401
+		}
+
+		plain, err := decryptCookie(key, ck.Value)
+		if err != nil {
+			return nil, Err401
+		}
+
+		var sess struct {
+			UserID string `json:"uid"`
+		}
+		if err := json.Unmarshal(plain, &sess); err != nil || sess.UserID == "" {
+			return nil, Err401
+		}
+
+		c := context.WithValue(r.Context(), core.UserIDKey, sess.UserID)
+		c = context.WithValue(c, socialHandlerCtxKey{}, sh)
+		return c, nil
+	}, nil
+}
+
+func (sh *socialHandler) login(p social.Provider, cfg *oauth2.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state := randomState()
+		http.SetCookie(w, &http.Cookie{
+			Name:     socialStateCookieName,
+			Value:    state,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   sh.cookieHTTPS,
+			MaxAge:   int((10 * time.Minute).Seconds()),
+		})
+		http.Redirect(w, r, cfg.AuthCodeURL(state), http.StatusFound)
+	}
+}
+
+func (sh *socialHandler) callback(p social.Provider, cfg *oauth2.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stateCk, err := r.Cookie(socialStateCookieName)
+		if err != nil || r.URL.Query().Get("state") != stateCk.Value {
+			http.Error(w, "invalid state", http.StatusBadRequest)
+			return
+		}
+
+		code := r.URL.Query().Get("code")
+		if code == "" {
+			http.Error(w, "missing code", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+
+		tok, err := cfg.Exchange(ctx, code)
+		if err != nil {
+			http.Error(w, "token exchange failed", http.StatusBadGateway)
+			return
+		}
+
+		id, err := p.FetchIdentity(cfg.Client(ctx, tok))
+		if err != nil {
+			http.Error(w, "failed to fetch identity", http.StatusBadGateway)
+			return
+		}
+
+		userID, err := sh.linkedUserID(ctx, p.Name, id.ExternalID)
+		if err == sql.ErrNoRows {
+			if sh.onUnlinked == nil {
+				http.Error(w, "no linked account", http.StatusForbidden)
+				return
+			}
+			userID, err = sh.onUnlinked(ctx, p.Name, id)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			if err := sh.upsertExternalLogin(ctx, p.Name, id.ExternalID, userID); err != nil {
+				http.Error(w, "failed to link account", http.StatusInternalServerError)
+				return
+			}
+		} else if err != nil {
+			http.Error(w, "failed to look up linked account", http.StatusInternalServerError)
+			return
+		}
+
+		sh.writeSession(w, userID)
+		http.Redirect(w, r, "/", http.StatusFound)
+	}
+}
+
+func (sh *socialHandler) linkedUserID(ctx context.Context, provider, externalID string) (string, error) {
+	var userID string
+	err := sh.db.QueryRowContext(ctx,
+		`SELECT user_id FROM external_login_user WHERE provider = $1 AND external_id = $2`,
+		provider, externalID).Scan(&userID)
+	return userID, err
+}
+
+func (sh *socialHandler) upsertExternalLogin(ctx context.Context, provider, externalID, userID string) error {
+	_, err := sh.db.ExecContext(ctx,
+		`INSERT INTO external_login_user (provider, external_id, user_id)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (provider, external_id) DO UPDATE SET user_id = excluded.user_id`,
+		provider, externalID, userID)
+	return err
+}
+
+func (sh *socialHandler) writeSession(w http.ResponseWriter, userID string) {
+	plain, err := json.Marshal(struct {
+		UserID string `json:"uid"`
+	}{userID})
+	if err != nil {
+		return
+	}
+
+	enc, err := encryptCookie(sh.key, plain)
+	if err != nil {
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     socialCookieName,
+		Value:    enc,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   sh.cookieHTTPS,
+		MaxAge:   sh.cookieMaxAge,
+	})
+}
+
+// LinkExternal attaches an external provider's identity, obtained by
+// exchanging the given OAuth2 authorization code, to the currently authenticated
+// user in ctx. Use this to let a signed-in user add another login method
+// to their account.
+func LinkExternal(ctx context.Context, provider, code string) error {
+	sh := socialHandlerFromContext(ctx)
+	if sh == nil {
+		return fmt.Errorf("social auth is not configured")
+	}
+
+	userID := UserID(ctx)
+	if userID == nil {
+		return Err401
+	}
+
+	p, ok := social.Providers[provider]
+	if !ok {
+		return fmt.Errorf("unknown social provider: %s", provider)
+	}
+
+	pc, ok := sh.ac.Social.Providers[provider]
+	if !ok {
+		return fmt.Errorf("social provider '%s' is not configured", provider)
+	}
+
+	cfg := p.Config(pc.ClientID, pc.ClientSecret, pc.RedirectURL, pc.Scopes)
+
+	tok, err := cfg.Exchange(ctx, code)
+	if err != nil {
+		return fmt.Errorf("token exchange failed: %w", err)
+	}
+
+	id, err := p.FetchIdentity(cfg.Client(ctx, tok))
+	if err != nil {
+		return fmt.Errorf("failed to fetch identity: %w", err)
+	}
+
+	return sh.upsertExternalLogin(ctx, provider, id.ExternalID, fmt.Sprintf("%v", userID))
+}
+
+// UnlinkExternal removes the link, if any, between the currently
+// authenticated user in ctx and the given external provider.
+func UnlinkExternal(ctx context.Context, provider string) error {
+	sh := socialHandlerFromContext(ctx)
+	if sh == nil {
+		return fmt.Errorf("social auth is not configured")
+	}
+
+	userID := UserID(ctx)
+	if userID == nil {
+		return Err401
+	}
+
+	_, err := sh.db.ExecContext(ctx,
+		`DELETE FROM external_login_user WHERE provider = $1 AND user_id = $2`,
+		provider, fmt.Sprintf("%v", userID))
+	return err
+}
+
+func randomState() string {
+	b := make([]byte, 24)
+	_, _ = rand.Read(b)
+	return strings.TrimRight(base64.URLEncoding.EncodeToString(b), "=")
+}